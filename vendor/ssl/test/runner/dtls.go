@@ -22,10 +22,291 @@ import (
 	"math/rand"
 	"net"
 	"slices"
+	"time"
 
 	"golang.org/x/crypto/cryptobyte"
 )
 
+const (
+	// dtlsMaxBatchedPackets bounds how many packets dtlsReadPackets will
+	// opportunistically drain in a single call.
+	dtlsMaxBatchedPackets = 16
+	// dtlsBatchReadTimeout is how long dtlsReadPackets waits for an
+	// additional already-in-flight packet before giving up and processing
+	// what has arrived so far.
+	dtlsBatchReadTimeout = 10 * time.Millisecond
+)
+
+// DTLSPacketLossConfig configures a simulated adversarial network sitting
+// between the two endpoints of a DTLS test connection. It lets a test
+// exercise BoringSSL's retransmit timer, its handling of reordered or
+// duplicated records, and its response to a path with a small MTU, none of
+// which are observable if the test driver simply hands records to the peer
+// reliably and in order.
+type DTLSPacketLossConfig struct {
+	// DropFlights, if non-empty, lists the (1-indexed) flight numbers that
+	// should be dropped entirely the first time they are sent. A flight is
+	// the batch of handshake messages (and any fragments or packets they
+	// are split across) written in response to whatever was last read from
+	// the peer; a retransmission of a dropped flight reuses that flight's
+	// number, and ACKs written in between do not count as flights of their
+	// own. This implements deterministic patterns like "drop flight 2,
+	// then flight 4".
+	DropFlights []int
+
+	// FlightDropProbability, if non-zero, is the probability, in the range
+	// [0, 1), that any given flight (other than one listed in DropFlights)
+	// is dropped the first time it is sent.
+	FlightDropProbability float64
+
+	// ReorderWindow, if non-zero, causes outgoing packets to be held back
+	// and released in a window of this many packets, scrambling the order
+	// in which the peer observes them.
+	ReorderWindow int
+
+	// Duplicates, if non-zero, causes every outgoing packet to be written
+	// this many additional times.
+	Duplicates int
+
+	// MTU, if non-zero, is the maximum size, in bytes, of an outgoing
+	// packet. Writes larger than this are expected to already have been
+	// split into multiple packets by the caller; this just enforces the
+	// limit rather than performing the splitting itself.
+	MTU int
+}
+
+// dtlsAdversarialConn wraps a net.Conn and, according to cfg, drops,
+// reorders, and duplicates the packets written to it before they reach the
+// peer. It implements net.Conn; DTLSServer and DTLSClient install it as
+// c.conn, in front of the connection they were given.
+type dtlsAdversarialConn struct {
+	net.Conn
+	cfg  *DTLSPacketLossConfig
+	rand *rand.Rand
+
+	// currentFlight is the flight number Write should attribute the next
+	// packet to. The Conn updates this via setFlight whenever it begins
+	// writing a new flight; a retransmission or an ACK, which write
+	// through this conn without calling setFlight again, are attributed
+	// to whatever flight was current when they were written.
+	currentFlight int
+	flightDrop    map[int]bool
+
+	// retransmitting is true while DTLSRetransmit is writing through this
+	// conn. A flight being retransmitted already had its chance to be
+	// dropped on its first transmission, so retransmitted packets are
+	// always delivered.
+	retransmitting bool
+
+	reorderBuf [][]byte
+}
+
+// setFlight records the flight number that subsequent writes belong to,
+// until the next call changes it.
+func (a *dtlsAdversarialConn) setFlight(flight int) {
+	a.currentFlight = flight
+}
+
+func newDTLSAdversarialConn(conn net.Conn, cfg *DTLSPacketLossConfig) *dtlsAdversarialConn {
+	return &dtlsAdversarialConn{
+		Conn:       conn,
+		cfg:        cfg,
+		rand:       rand.New(rand.NewSource(0)),
+		flightDrop: make(map[int]bool),
+	}
+}
+
+// dropsFlight reports whether the flight currently being written should be
+// dropped, per DropFlights or FlightDropProbability. The drop decision for a
+// flight is made once, the first time any of its packets is written, and
+// then applies to every packet of that flight's first transmission; the
+// retransmission is always let through, matching a real network that loses
+// a flight on its first transmission but not on the retransmit.
+func (a *dtlsAdversarialConn) dropsFlight(flight int) bool {
+	if a.retransmitting {
+		return false
+	}
+	if drop, ok := a.flightDrop[flight]; ok {
+		return drop
+	}
+	drop := false
+	for _, n := range a.cfg.DropFlights {
+		if n == flight {
+			drop = true
+			break
+		}
+	}
+	if !drop && a.cfg.FlightDropProbability > 0 && a.rand.Float64() < a.cfg.FlightDropProbability {
+		drop = true
+	}
+	a.flightDrop[flight] = drop
+	return drop
+}
+
+func (a *dtlsAdversarialConn) Write(b []byte) (int, error) {
+	if a.cfg.MTU != 0 && len(b) > a.cfg.MTU {
+		return 0, fmt.Errorf("dtls: packet of length %d exceeds simulated MTU of %d", len(b), a.cfg.MTU)
+	}
+
+	n := len(b)
+	if a.dropsFlight(a.currentFlight) {
+		return n, nil
+	}
+
+	copies := 1 + a.cfg.Duplicates
+	for i := 0; i < copies; i++ {
+		if err := a.enqueue(slices.Clone(b)); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// enqueue releases packet to the underlying connection, possibly after
+// holding it back to scramble the delivery order.
+func (a *dtlsAdversarialConn) enqueue(packet []byte) error {
+	if a.cfg.ReorderWindow == 0 {
+		_, err := a.Conn.Write(packet)
+		return err
+	}
+
+	a.reorderBuf = append(a.reorderBuf, packet)
+	if len(a.reorderBuf) < a.cfg.ReorderWindow {
+		return nil
+	}
+
+	perm := a.rand.Perm(len(a.reorderBuf))
+	for _, i := range perm {
+		if _, err := a.Conn.Write(a.reorderBuf[i]); err != nil {
+			return err
+		}
+	}
+	a.reorderBuf = a.reorderBuf[:0]
+	return nil
+}
+
+// flushReorderBuf releases any packets still held back for reordering. It is
+// used when a flight finishes and the test wants delivery to catch up rather
+// than waiting for the window to fill.
+func (a *dtlsAdversarialConn) flushReorderBuf() error {
+	perm := a.rand.Perm(len(a.reorderBuf))
+	for _, i := range perm {
+		if _, err := a.Conn.Write(a.reorderBuf[i]); err != nil {
+			return err
+		}
+	}
+	a.reorderBuf = a.reorderBuf[:0]
+	return nil
+}
+
+// errDTLSRecordReplayed is returned by the record header readers when a
+// record falls outside the anti-replay window: it is a duplicate or too old
+// to be tracked. dtlsDoReadRecord treats it as a signal to silently discard
+// the record and read the next one, rather than as a fatal error.
+var errDTLSRecordReplayed = errors.New("dtls: record replayed or too old")
+
+// dtlsReplayWindow implements the per-epoch sliding anti-replay window of
+// RFC 6347 section 4.1.2.6 / RFC 9147 section 5: a record above the window
+// always passes and slides the window forward; a record inside the window
+// passes only the first time; a record below the window never passes.
+type dtlsReplayWindow struct {
+	epoch   uint64
+	highest uint64
+	seenAny bool
+	// bitmap tracks the |bits| sequence numbers below highest. Bit i
+	// (0-indexed) reflects whether sequence number highest-i-1 has been seen.
+	bitmap uint64
+}
+
+// accept reports whether a record with the given sequence number is new
+// under the window of the given width, and if so, marks it seen.
+func (w *dtlsReplayWindow) accept(seqNum uint64, bits uint64) bool {
+	if !w.seenAny {
+		w.seenAny = true
+		w.highest = seqNum
+		w.bitmap = 0
+		return true
+	}
+	if seqNum > w.highest {
+		shift := seqNum - w.highest
+		if shift > bits {
+			w.bitmap = 0
+		} else {
+			// shift == bits still keeps the old highest inside the window,
+			// at bit index bits-1, so it must go through the same
+			// preserving formula rather than being wiped.
+			w.bitmap = (w.bitmap << shift) | (uint64(1) << (shift - 1))
+		}
+		w.highest = seqNum
+		return true
+	}
+	shift := w.highest - seqNum
+	if shift == 0 || shift > bits {
+		// shift == 0 is a replay of the highest sequence number itself;
+		// shift > bits is older than the window can track.
+		return false
+	}
+	mask := uint64(1) << (shift - 1)
+	if w.bitmap&mask != 0 {
+		return false
+	}
+	w.bitmap |= mask
+	return true
+}
+
+// dtlsReplayWindowBits returns the configured replay window width, in
+// records. The window is backed by a single uint64 bitmap, so widths wider
+// than 64 are clamped.
+func (c *Conn) dtlsReplayWindowBits() uint64 {
+	bits := c.config.DTLSReplayWindowBits
+	if bits == 0 {
+		bits = 64
+	}
+	if bits > 64 {
+		bits = 64
+	}
+	return uint64(bits)
+}
+
+// dtlsCheckReplay applies the anti-replay window to a record in the given
+// epoch with the given 48-bit sequence number.
+func (c *Conn) dtlsCheckReplay(epoch, seqNum uint64) bool {
+	if c.replayWindows == nil {
+		c.replayWindows = make(map[uint64]*dtlsReplayWindow)
+	}
+	w := c.replayWindows[epoch]
+	if w == nil {
+		w = &dtlsReplayWindow{epoch: epoch}
+		c.replayWindows[epoch] = w
+	}
+	return w.accept(seqNum, c.dtlsReplayWindowBits())
+}
+
+// seq48ToUint64 interprets a 6-byte big-endian sequence number as a uint64.
+func seq48ToUint64(seq []byte) uint64 {
+	var n uint64
+	for _, b := range seq {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+// dtlsReconstructSeq reconstructs a full sequence number from the low 16
+// bits carried on the wire, per RFC 9147 section 4: of the candidates that
+// share those low 16 bits, it picks the one closest to highest, the highest
+// sequence number accepted so far in the epoch.
+func dtlsReconstructSeq(highest uint64, wireSeq uint16) uint64 {
+	seq := highest&^0xffff | uint64(wireSeq)
+	if seq+0x8000 <= highest {
+		if next := seq + 0x10000; next <= 0xffffffffffff {
+			seq = next
+		}
+	} else if seq > highest+0x8000 && seq >= 0x10000 {
+		seq -= 0x10000
+	}
+	return seq
+}
+
 func (c *Conn) readDTLS13RecordHeader(b []byte) (headerLen int, recordLen int, recTyp recordType, seq []byte, err error) {
 	// The DTLS 1.3 record header starts with the type byte containing
 	// 0b001CSLEE, where C, S, L, and EE are bits with the following
@@ -38,18 +319,37 @@ func (c *Conn) readDTLS13RecordHeader(b []byte) (headerLen int, recordLen int, r
 	//
 	// A real DTLS implementation would parse these bits and take
 	// appropriate action based on them. However, this is a test
-	// implementation, and the code we are testing only ever sends C=0, S=1,
-	// L=1. This code expects those bits to be set and will error if
+	// implementation, and the code we are testing only ever sends S=1, L=1,
+	// with C set exactly when a connection ID was negotiated in our
+	// direction. This code expects those bits to be set and will error if
 	// anything else is set. This means we expect the type byte to look like
-	// 0b001011EE, or 0x2c-0x2f.
-	recordHeaderLen := 5
-	if len(b) < recordHeaderLen {
+	// 0b001C11EE, or, ignoring the epoch bits, 0x2c or 0x3c.
+	if len(b) < 1 {
 		return 0, 0, 0, nil, errors.New("dtls: failed to read record header")
 	}
 	typ := b[0]
-	if typ&0xfc != 0x2c {
+	if typ&0xec != 0x2c {
 		return 0, 0, 0, nil, errors.New("dtls: DTLS 1.3 record header has bad type byte")
 	}
+
+	// RFC 9146: when the C bit is set, the Connection ID immediately
+	// follows the type byte. Its length is not self-describing on the
+	// wire; it is whatever length was negotiated via the connection_id
+	// extension, which the test driver tracks on c.config.
+	hasCID := typ&0x10 != 0
+	if hasCID != (len(c.config.DTLSExpectConnectionID) != 0) {
+		return 0, 0, 0, nil, errors.New("dtls: unexpected connection ID bit in record header")
+	}
+	cidLen := len(c.config.DTLSExpectConnectionID)
+
+	recordHeaderLen := 1 + cidLen + 4
+	if len(b) < recordHeaderLen {
+		return 0, 0, 0, nil, errors.New("dtls: failed to read record header")
+	}
+	if cidLen != 0 && !bytes.Equal(b[1:1+cidLen], c.config.DTLSExpectConnectionID) {
+		return 0, 0, 0, nil, errors.New("dtls: received record with unexpected connection ID")
+	}
+
 	// For test purposes, require the epoch received be the same as the
 	// epoch we expect to receive.
 	epoch := typ & 0x03
@@ -57,34 +357,50 @@ func (c *Conn) readDTLS13RecordHeader(b []byte) (headerLen int, recordLen int, r
 		c.sendAlert(alertIllegalParameter)
 		return 0, 0, 0, nil, c.in.setErrorLocked(fmt.Errorf("dtls: bad epoch"))
 	}
-	wireSeq := b[1:3]
+	wireSeq := b[1+cidLen : 3+cidLen]
 	if !c.config.Bugs.NullAllCiphers {
 		sample := b[recordHeaderLen:]
 		mask := c.in.recordNumberEncrypter.generateMask(sample)
 		xorSlice(wireSeq, mask)
 	}
 	decWireSeq := binary.BigEndian.Uint16(wireSeq)
-	// Reconstruct the sequence number from the low 16 bits on the wire.
-	// A real implementation would compute the full sequence number that is
-	// closest to the highest successfully decrypted record in the
-	// identified epoch. Since this test implementation errors on decryption
-	// failures instead of simply discarding packets, it reconstructs a
-	// sequence number that is not less than c.in.seq. (This matches the
-	// behavior of the check of the sequence number in the old record
-	// header format.)
+	recordLen = int(b[3+cidLen])<<8 | int(b[4+cidLen])
+
 	seqInt := binary.BigEndian.Uint64(c.in.seq[:])
 	// c.in.seq has the epoch in the upper two bytes - clear those.
 	seqInt = seqInt &^ (0xffff << 48)
-	newSeq := seqInt&^0xffff | uint64(decWireSeq)
-	if newSeq < seqInt {
-		newSeq += 0x10000
+
+	var newSeq uint64
+	if c.config.Bugs.StrictDTLSSequence {
+		// Reconstruct the sequence number from the low 16 bits on the
+		// wire, rounding up to the next value that is not less than
+		// c.in.seq. This matches the behavior of the monotonic check of
+		// the old record header format and rejects everything but
+		// strictly increasing sequence numbers.
+		newSeq = seqInt&^0xffff | uint64(decWireSeq)
+		if newSeq < seqInt {
+			newSeq += 0x10000
+		}
+	} else {
+		// Reconstruct the full sequence number as the value closest to
+		// the highest sequence number accepted so far in this epoch, per
+		// RFC 9147 section 4.
+		newSeq = dtlsReconstructSeq(seqInt, decWireSeq)
 	}
 
 	seq = make([]byte, 8)
 	binary.BigEndian.PutUint64(seq, newSeq)
-	copy(c.in.seq[2:], seq[2:])
 
-	recordLen = int(b[3])<<8 | int(b[4])
+	if !c.config.Bugs.StrictDTLSSequence {
+		epoch16 := binary.BigEndian.Uint16(c.in.seq[:2])
+		if !c.dtlsCheckReplay(uint64(epoch16), newSeq) {
+			return recordHeaderLen, recordLen, 0, nil, errDTLSRecordReplayed
+		}
+	}
+	if newSeq > seqInt {
+		copy(c.in.seq[2:], seq[2:])
+	}
+
 	return recordHeaderLen, recordLen, 0, seq, nil
 }
 
@@ -132,90 +448,260 @@ func (c *Conn) readDTLSRecordHeader(b []byte) (headerLen int, recordLen int, typ
 	}
 	epoch := b[3:5]
 	seq = b[5:11]
-	// For test purposes, require the sequence number be monotonically
-	// increasing, so c.in includes the minimum next sequence number. Gaps
-	// may occur if packets failed to be sent out. A real implementation
-	// would maintain a replay window and such.
+	recordLen = int(b[11])<<8 | int(b[12])
 	if !bytes.Equal(epoch, c.in.seq[:2]) {
 		c.sendAlert(alertIllegalParameter)
 		return 0, 0, 0, nil, c.in.setErrorLocked(fmt.Errorf("dtls: bad epoch"))
 	}
-	if bytes.Compare(seq, c.in.seq[2:]) < 0 {
-		c.sendAlert(alertIllegalParameter)
-		return 0, 0, 0, nil, c.in.setErrorLocked(fmt.Errorf("dtls: bad sequence number"))
+	if c.config.Bugs.StrictDTLSSequence {
+		// Require the sequence number be monotonically increasing, so
+		// c.in includes the minimum next sequence number. Gaps may occur
+		// if packets failed to be sent out.
+		if bytes.Compare(seq, c.in.seq[2:]) < 0 {
+			c.sendAlert(alertIllegalParameter)
+			return 0, 0, 0, nil, c.in.setErrorLocked(fmt.Errorf("dtls: bad sequence number"))
+		}
+		copy(c.in.seq[2:], seq)
+	} else {
+		// Otherwise maintain a sliding anti-replay window per RFC 6347
+		// section 4.1.2.6: records above the window are accepted and
+		// slide it forward; records inside the window are accepted once;
+		// everything else is a replay and is dropped silently.
+		if !c.dtlsCheckReplay(uint64(binary.BigEndian.Uint16(epoch)), seq48ToUint64(seq)) {
+			return recordHeaderLen, recordLen, typ, nil, errDTLSRecordReplayed
+		}
+		if bytes.Compare(seq, c.in.seq[2:]) > 0 {
+			copy(c.in.seq[2:], seq)
+		}
 	}
-	copy(c.in.seq[2:], seq)
-	recordLen = int(b[11])<<8 | int(b[12])
 	return recordHeaderLen, recordLen, typ, b[3:11], nil
 }
 
-func (c *Conn) writeACKs(seqnums []uint64) {
+// DTLSRecordNumber identifies a single DTLS record by epoch and sequence
+// number. This is the unit that RFC 9147 ACK records acknowledge.
+type DTLSRecordNumber struct {
+	Epoch, Sequence uint64
+}
+
+// pendingFragment is a record that has been written to the wire but not yet
+// acknowledged by the peer, retained so DTLSRetransmit can resend it
+// verbatim.
+type pendingFragment struct {
+	wire []byte
+}
+
+func (c *Conn) writeACKRecords(nums []DTLSRecordNumber) {
 	recordNumbers := new(cryptobyte.Builder)
-	epoch := binary.BigEndian.Uint16(c.in.seq[:2])
 	recordNumbers.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
-		for _, seq := range seqnums {
-			b.AddUint64(uint64(epoch))
-			b.AddUint64(seq)
+		for _, num := range nums {
+			b.AddUint64(num.Epoch)
+			b.AddUint64(num.Sequence)
 		}
 	})
 	c.writeRecord(recordTypeACK, recordNumbers.BytesOrPanic())
 }
 
+func (c *Conn) writeACKs(seqnums []uint64) {
+	epoch := uint64(binary.BigEndian.Uint16(c.in.seq[:2]))
+	nums := make([]DTLSRecordNumber, len(seqnums))
+	for i, seq := range seqnums {
+		nums[i] = DTLSRecordNumber{Epoch: epoch, Sequence: seq}
+	}
+	c.writeACKRecords(nums)
+}
+
+// processACK parses the body of an RFC 9147 ACK record and removes each
+// acknowledged record from c.sentRecords, so DTLSRetransmit only resends the
+// un-acked subset of a flight.
+func (c *Conn) processACK(data []byte) error {
+	reader := cryptobyte.String(data)
+	var body cryptobyte.String
+	if !reader.ReadUint16LengthPrefixed(&body) || !reader.Empty() || len(body)%16 != 0 {
+		return errors.New("dtls: bad ACK record")
+	}
+	for !body.Empty() {
+		var epoch, seq uint64
+		if !body.ReadUint64(&epoch) || !body.ReadUint64(&seq) {
+			return errors.New("dtls: bad ACK record")
+		}
+		delete(c.sentRecords, DTLSRecordNumber{Epoch: epoch, Sequence: seq})
+	}
+	return nil
+}
+
+// DTLSRetransmit resends every record that has been sent but not yet
+// acknowledged by the peer, in ascending (epoch, sequence) order. Tests use
+// this to drive BoringSSL's retransmit timer and confirm it retransmits
+// only the un-acked subset of a flight.
+func (c *Conn) DTLSRetransmit() error {
+	if a, ok := c.conn.(*dtlsAdversarialConn); ok {
+		a.retransmitting = true
+		defer func() { a.retransmitting = false }()
+	}
+
+	nums := make([]DTLSRecordNumber, 0, len(c.sentRecords))
+	for num := range c.sentRecords {
+		nums = append(nums, num)
+	}
+	slices.SortFunc(nums, func(a, b DTLSRecordNumber) int {
+		if a.Epoch != b.Epoch {
+			return int(a.Epoch - b.Epoch)
+		}
+		return int(a.Sequence - b.Sequence)
+	})
+	for _, num := range nums {
+		if _, err := c.conn.Write(c.sentRecords[num].wire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *Conn) dtlsDoReadRecord(want recordType) (recordType, []byte, error) {
-	// Read a new packet only if the current one is empty.
-	var newPacket bool
-	if c.rawInput.Len() == 0 {
-		// Pick some absurdly large buffer size.
-		c.rawInput.Grow(maxCiphertext + dtlsMaxRecordHeaderLen)
-		buf := c.rawInput.AvailableBuffer()
-		n, err := c.conn.Read(buf[:cap(buf)])
-		if err != nil {
+	for {
+		// Read a new packet only if the current one is empty.
+		if c.rawInput.Len() == 0 {
+			if err := c.dtlsReadPackets(); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		// Consume the next record from the buffer.
+		recordHeaderLen, n, typ, seq, err := c.readDTLSRecordHeader(c.rawInput.Bytes())
+		if err != nil && err != errDTLSRecordReplayed {
 			return 0, nil, err
 		}
-		if c.config.Bugs.MaxPacketLength != 0 && n > c.config.Bugs.MaxPacketLength {
-			return 0, nil, fmt.Errorf("dtls: exceeded maximum packet length")
+		if n > maxCiphertext || c.rawInput.Len() < recordHeaderLen+n {
+			c.sendAlert(alertRecordOverflow)
+			return 0, nil, c.in.setErrorLocked(fmt.Errorf("dtls: oversized record received with length %d", n))
+		}
+		if err == errDTLSRecordReplayed {
+			// The record is a duplicate or too old for the replay
+			// window. A real peer would simply ignore it; discard it
+			// and move on to the next record rather than tearing down
+			// the connection.
+			c.rawInput.Next(recordHeaderLen + n)
+			c.dtlsConsumeFromPacket(recordHeaderLen + n)
+			continue
+		}
+		startedPacket, finishedPacket := c.dtlsConsumeFromPacket(recordHeaderLen + n)
+		b := c.rawInput.Next(recordHeaderLen + n)
+
+		// Process message.
+		ok, encTyp, data, alertValue := c.in.decrypt(seq, recordHeaderLen, b)
+		if !ok {
+			// A real DTLS implementation would silently ignore bad records,
+			// but we want to notice errors from the implementation under
+			// test.
+			return 0, nil, c.in.setErrorLocked(c.sendAlert(alertValue))
+		}
+		if c.config.Bugs.ACKEveryRecord {
+			c.writeACKs([]uint64{binary.BigEndian.Uint64(seq)})
+		}
+		if len(c.config.Bugs.SendACK) > 0 {
+			c.writeACKRecords(c.config.Bugs.SendACK)
 		}
-		c.rawInput.Write(buf[:n])
-		newPacket = true
+
+		if typ == 0 {
+			// readDTLSRecordHeader sets typ=0 when decoding the DTLS 1.3
+			// record header. When the new record header format is used, the
+			// type is returned by decrypt() in encTyp.
+			typ = encTyp
+		}
+
+		// Require that ChangeCipherSpec always share a packet with either the
+		// previous or next handshake message. startedPacket && finishedPacket
+		// means this record was, by itself, the entire physical packet it
+		// arrived in -- tracked per-packet rather than via c.rawInput.Len()
+		// so that dtlsReadPackets batching several packets into rawInput at
+		// once doesn't mask a ChangeCipherSpec that genuinely arrived alone.
+		if typ == recordTypeChangeCipherSpec && startedPacket && finishedPacket {
+			return 0, nil, c.in.setErrorLocked(fmt.Errorf("dtls: ChangeCipherSpec not packed together with Finished"))
+		}
+
+		if typ == recordTypeACK {
+			if err := c.processACK(data); err != nil {
+				return 0, nil, c.in.setErrorLocked(c.sendAlert(alertDecodeError))
+			}
+			continue
+		}
+
+		// Whatever we write next is in response to this record, so it
+		// starts a new flight rather than continuing whatever flight, if
+		// any, was last written.
+		c.dtlsFlightOpen = false
+
+		return typ, data, nil
 	}
+}
 
-	// Consume the next record from the buffer.
-	recordHeaderLen, n, typ, seq, err := c.readDTLSRecordHeader(c.rawInput.Bytes())
+// dtlsReadPackets reads one or more packets from c.conn into c.rawInput.
+// Under a reliable, ordered transport a single Read is always sufficient.
+// With the adversarial transport installed by DTLSPacketLossConfig,
+// however, a burst of reordered or duplicated packets can arrive back to
+// back; opportunistically draining them into rawInput in one call means the
+// caller sees them as soon as they are available rather than one at a time.
+func (c *Conn) dtlsReadPackets() error {
+	n, err := c.dtlsReadPacket()
 	if err != nil {
-		return 0, nil, err
-	}
-	if n > maxCiphertext || c.rawInput.Len() < recordHeaderLen+n {
-		c.sendAlert(alertRecordOverflow)
-		return 0, nil, c.in.setErrorLocked(fmt.Errorf("dtls: oversized record received with length %d", n))
+		return err
 	}
-	b := c.rawInput.Next(recordHeaderLen + n)
+	read := 1
 
-	// Process message.
-	ok, encTyp, data, alertValue := c.in.decrypt(seq, recordHeaderLen, b)
-	if !ok {
-		// A real DTLS implementation would silently ignore bad records,
-		// but we want to notice errors from the implementation under
-		// test.
-		return 0, nil, c.in.setErrorLocked(c.sendAlert(alertValue))
-	}
-	if c.config.Bugs.ACKEveryRecord {
-		c.writeACKs([]uint64{binary.BigEndian.Uint64(seq)})
+	// Only the adversarial transport can cause multiple packets to be
+	// ready at once; with a plain connection, further reads would simply
+	// block.
+	_, adversarial := c.conn.(*dtlsAdversarialConn)
+	for adversarial && n > 0 && read < dtlsMaxBatchedPackets {
+		if err := c.conn.SetReadDeadline(time.Now().Add(dtlsBatchReadTimeout)); err != nil {
+			break
+		}
+		n, err = c.dtlsReadPacket()
+		c.conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return err
+		}
+		read++
 	}
+	return nil
+}
 
-	if typ == 0 {
-		// readDTLSRecordHeader sets typ=0 when decoding the DTLS 1.3
-		// record header. When the new record header format is used, the
-		// type is returned by decrypt() in encTyp.
-		typ = encTyp
+// dtlsReadPacket reads a single packet from c.conn into c.rawInput and
+// returns its length.
+func (c *Conn) dtlsReadPacket() (int, error) {
+	// Pick some absurdly large buffer size.
+	c.rawInput.Grow(maxCiphertext + dtlsMaxRecordHeaderLen)
+	buf := c.rawInput.AvailableBuffer()
+	n, err := c.conn.Read(buf[:cap(buf)])
+	if err != nil {
+		return 0, err
 	}
-
-	// Require that ChangeCipherSpec always share a packet with either the
-	// previous or next handshake message.
-	if newPacket && typ == recordTypeChangeCipherSpec && c.rawInput.Len() == 0 {
-		return 0, nil, c.in.setErrorLocked(fmt.Errorf("dtls: ChangeCipherSpec not packed together with Finished"))
+	if c.config.Bugs.MaxPacketLength != 0 && n > c.config.Bugs.MaxPacketLength {
+		return 0, fmt.Errorf("dtls: exceeded maximum packet length")
 	}
+	c.rawInput.Write(buf[:n])
+	c.dtlsPacketLens = append(c.dtlsPacketLens, n)
+	return n, nil
+}
 
-	return typ, data, nil
+// dtlsConsumeFromPacket advances the per-packet boundary accounting by n
+// bytes just consumed from the front of c.rawInput, and reports whether
+// those bytes began, and/or ended, exactly at the boundary of the physical
+// packet they were read in. dtlsReadPackets may batch several packets into
+// rawInput at once, so this -- not c.rawInput.Len() -- is what tells
+// dtlsDoReadRecord whether a record had its packet to itself.
+func (c *Conn) dtlsConsumeFromPacket(n int) (startedPacket, finishedPacket bool) {
+	startedPacket = c.dtlsPacketBytesRead == 0
+	c.dtlsPacketBytesRead += n
+	if len(c.dtlsPacketLens) > 0 && c.dtlsPacketBytesRead >= c.dtlsPacketLens[0] {
+		c.dtlsPacketLens = c.dtlsPacketLens[1:]
+		c.dtlsPacketBytesRead = 0
+		finishedPacket = true
+	}
+	return
 }
 
 func (c *Conn) makeFragment(header, data []byte, fragOffset, fragLen int) []byte {
@@ -288,6 +774,19 @@ func (c *Conn) dtlsWriteRecord(typ recordType, data []byte) (n int, err error) {
 		return
 	}
 
+	// A flight is the batch of handshake messages sent in response to
+	// whatever was last read from the peer. The first handshake message
+	// written since that read opens a new flight; everything else sent
+	// before the next read (additional messages, their fragments, a
+	// following ChangeCipherSpec) belongs to the same one.
+	if !c.dtlsFlightOpen {
+		c.dtlsFlight++
+		c.dtlsFlightOpen = true
+		if a, ok := c.conn.(*dtlsAdversarialConn); ok {
+			a.setFlight(c.dtlsFlight)
+		}
+	}
+
 	if c.out.cipher == nil && c.config.Bugs.StrayChangeCipherSpec {
 		_, err = c.dtlsPackRecord(recordTypeChangeCipherSpec, []byte{1}, false)
 		if err != nil {
@@ -372,8 +871,9 @@ func (c *Conn) dtlsWriteRecord(typ recordType, data []byte) (n int, err error) {
 // dtlsPackHandshake packs the pending handshake flight into the pending
 // record. Callers should follow up with dtlsFlushPacket to write the packets.
 func (c *Conn) dtlsPackHandshake() error {
-	// This is a test-only DTLS implementation, so there is no need to
-	// retain |c.pendingFragments| for a future retransmit.
+	// c.pendingFragments itself need not be retained once packed: the
+	// packed records are what get retransmitted, and dtlsPackRecord
+	// retains those in c.sentRecords.
 	var fragments [][]byte
 	fragments, c.pendingFragments = c.pendingFragments, fragments
 
@@ -440,8 +940,12 @@ func (c *Conn) appendDTLS13RecordHeader(b, seq []byte, recordLen int) []byte {
 	// Set the top 3 bits on the type byte to indicate the DTLS 1.3 record
 	// header format.
 	typ := byte(0x20)
-	// Set the Connection ID bit
-	if c.config.Bugs.DTLS13RecordHeaderSetCIDBit && c.handshakeComplete {
+	// Set the Connection ID bit. This is set for real when a connection ID
+	// was negotiated for records we send (RFC 9146), and can additionally
+	// be forced on, with no actual CID emitted, to test the peer's
+	// handling of a malformed header.
+	haveCID := len(c.config.DTLSSendConnectionID) != 0
+	if haveCID || (c.config.Bugs.DTLS13RecordHeaderSetCIDBit && c.handshakeComplete) {
 		typ |= 0x10
 	}
 	// Set the sequence number length bit
@@ -455,6 +959,12 @@ func (c *Conn) appendDTLS13RecordHeader(b, seq []byte, recordLen int) []byte {
 	// Set the epoch bits
 	typ |= seq[1] & 0x3
 	b = append(b, typ)
+	if haveCID {
+		// The CID is carried in the additional data covered by the AEAD,
+		// so it must be part of the record header produced here rather
+		// than appended separately before encryption.
+		b = append(b, c.config.DTLSSendConnectionID...)
+	}
 	if c.config.DTLSUseShortSeqNums {
 		b = append(b, seq[7])
 	} else {
@@ -520,8 +1030,21 @@ func (c *Conn) dtlsPackRecord(typ recordType, data []byte, mustPack bool) (n int
 		if c.config.DTLSUseShortSeqNums {
 			seqLen = 1
 		}
-		// The sequence number starts at index 1 in the record header.
-		xorSlice(record[1:1+seqLen], mask)
+		// The sequence number follows the type byte and, when a
+		// connection ID is being sent, the connection ID itself.
+		cidLen := len(c.config.DTLSSendConnectionID)
+		xorSlice(record[1+cidLen:1+cidLen+seqLen], mask)
+	}
+
+	// Retain handshake records so they can be retransmitted verbatim until
+	// the peer ACKs them.
+	if typ == recordTypeHandshake || typ == recordTypeChangeCipherSpec {
+		epoch := uint64(binary.BigEndian.Uint16(seq[:2]))
+		seqNum := binary.BigEndian.Uint64(seq) &^ (0xffff << 48)
+		if c.sentRecords == nil {
+			c.sentRecords = make(map[DTLSRecordNumber]*pendingFragment)
+		}
+		c.sentRecords[DTLSRecordNumber{Epoch: epoch, Sequence: seqNum}] = &pendingFragment{wire: slices.Clone(record)}
 	}
 
 	// Flush the current pending packet if necessary.
@@ -553,15 +1076,97 @@ func (c *Conn) dtlsFlushPacket() error {
 	}
 	_, err := c.conn.Write(c.pendingPacket)
 	c.pendingPacket = nil
-	return err
+	if err != nil {
+		return err
+	}
+	// A flight has just been written out. If the adversarial transport is
+	// holding packets back for reordering, let them go now rather than
+	// waiting for the window to fill, so the flight is not stalled
+	// indefinitely behind a read.
+	if a, ok := c.conn.(*dtlsAdversarialConn); ok {
+		return a.flushReorderBuf()
+	}
+	return nil
 }
 
+// dtlsByteRange is a half-open [start, end) range of bytes of a handshake
+// message that have been received so far.
+type dtlsByteRange struct {
+	start, end int
+}
+
+// dtlsPendingHandshakeMessage reassembles a single handshake message
+// (identified by its DTLS fragment sequence number) from fragments that may
+// arrive out of order and may overlap.
+type dtlsPendingHandshakeMessage struct {
+	header [4]byte
+	msgLen int
+	data   []byte
+	// have is the sorted, non-overlapping set of byte ranges of data that
+	// have been filled in so far.
+	have []dtlsByteRange
+}
+
+// addFragment incorporates a fragment covering [fragOff, fragOff+len(fragment))
+// into the message. Bytes already received are required to match; this
+// matches a peer that retransmits the same fragment, possibly as part of a
+// differently-split flight.
+func (m *dtlsPendingHandshakeMessage) addFragment(fragOff int, fragment []byte) error {
+	fragEnd := fragOff + len(fragment)
+	for _, r := range m.have {
+		lo, hi := max(fragOff, r.start), min(fragEnd, r.end)
+		if lo < hi && !bytes.Equal(fragment[lo-fragOff:hi-fragOff], m.data[lo:hi]) {
+			return errors.New("dtls: overlapping fragment contents do not match")
+		}
+	}
+	copy(m.data[fragOff:fragEnd], fragment)
+
+	m.have = append(m.have, dtlsByteRange{fragOff, fragEnd})
+	slices.SortFunc(m.have, func(a, b dtlsByteRange) int { return a.start - b.start })
+	merged := m.have[:0]
+	for _, r := range m.have {
+		if n := len(merged); n > 0 && r.start <= merged[n-1].end {
+			if r.end > merged[n-1].end {
+				merged[n-1].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	m.have = merged
+	return nil
+}
+
+// complete reports whether every byte of the message has been received.
+func (m *dtlsPendingHandshakeMessage) complete() bool {
+	return len(m.have) == 1 && m.have[0].start == 0 && m.have[0].end == m.msgLen
+}
+
+// bytes returns the reassembled message, including its 4-byte TLS handshake
+// header. It must only be called once complete returns true.
+func (m *dtlsPendingHandshakeMessage) bytes() []byte {
+	ret := make([]byte, 0, 4+len(m.data))
+	ret = append(ret, m.header[:]...)
+	ret = append(ret, m.data...)
+	return ret
+}
+
+// dtlsDoReadHandshake returns the next handshake message, in
+// recvHandshakeSeq order. Fragments may arrive in any order, overlap, and be
+// interleaved with fragments of later messages; this reassembles each
+// pending message independently, keyed by its fragment sequence number, and
+// buffers messages that complete ahead of the one currently expected so a
+// later call can return them once the earlier message has been delivered.
+// This is required to drive BoringSSL under simulated loss and reordering,
+// including its own fragment interleaving behavior.
 func (c *Conn) dtlsDoReadHandshake() ([]byte, error) {
-	// Assemble a full handshake message.  For test purposes, this
-	// implementation assumes fragments arrive in order. It may
-	// need to be cleverer if we ever test BoringSSL's retransmit
-	// behavior.
-	for len(c.handMsg) < 4+c.handMsgLen {
+	for {
+		if msg := c.handshakeMessages[c.recvHandshakeSeq]; msg != nil && msg.complete() {
+			delete(c.handshakeMessages, c.recvHandshakeSeq)
+			c.recvHandshakeSeq++
+			return msg.bytes(), nil
+		}
+
 		// Get a new handshake record if the previous has been
 		// exhausted.
 		if c.hand.Len() == 0 {
@@ -571,6 +1176,7 @@ func (c *Conn) dtlsDoReadHandshake() ([]byte, error) {
 			if err := c.readRecord(recordTypeHandshake); err != nil {
 				return nil, err
 			}
+			continue
 		}
 
 		// Read the next fragment. It must fit entirely within
@@ -589,37 +1195,37 @@ func (c *Conn) dtlsDoReadHandshake() ([]byte, error) {
 		}
 		fragment := c.hand.Next(fragLen)
 
-		// Check it's a fragment for the right message.
-		if fragSeq != c.recvHandshakeSeq {
-			return nil, errors.New("dtls: bad handshake sequence number")
+		// A fragment for a message we have already delivered is a
+		// stale retransmit; simply drop it.
+		if fragSeq < c.recvHandshakeSeq {
+			continue
 		}
 
-		// Check that the length is consistent.
-		if c.handMsg == nil {
-			c.handMsgLen = fragN
-			if c.handMsgLen > maxHandshake {
+		msg := c.handshakeMessages[fragSeq]
+		if msg == nil {
+			if fragN > maxHandshake {
 				return nil, c.in.setErrorLocked(c.sendAlert(alertInternalError))
 			}
-			// Start with the TLS handshake header,
-			// without the DTLS bits.
-			c.handMsg = slices.Clone(header[:4])
-		} else if fragN != c.handMsgLen {
+			msg = &dtlsPendingHandshakeMessage{msgLen: fragN, data: make([]byte, fragN)}
+			// The 4-byte header is retained from the first-seen
+			// fragment and cross-checked against fragN from every
+			// subsequent fragment below.
+			copy(msg.header[:], header[:4])
+			if c.handshakeMessages == nil {
+				c.handshakeMessages = make(map[uint16]*dtlsPendingHandshakeMessage)
+			}
+			c.handshakeMessages[fragSeq] = msg
+		} else if fragN != msg.msgLen {
 			return nil, errors.New("dtls: bad handshake length")
 		}
 
-		// Add the fragment to the pending message.
-		if 4+fragOff != len(c.handMsg) {
-			return nil, errors.New("dtls: bad fragment offset")
-		}
-		if fragOff+fragLen > c.handMsgLen {
+		if fragOff+fragLen > msg.msgLen {
 			return nil, errors.New("dtls: bad fragment length")
 		}
-		c.handMsg = append(c.handMsg, fragment...)
+		if err := msg.addFragment(fragOff, fragment); err != nil {
+			return nil, err
+		}
 	}
-	c.recvHandshakeSeq++
-	ret := c.handMsg
-	c.handMsg, c.handMsgLen = nil, 0
-	return ret, nil
 }
 
 // DTLSServer returns a new DTLS server side connection
@@ -627,6 +1233,9 @@ func (c *Conn) dtlsDoReadHandshake() ([]byte, error) {
 // The configuration config must be non-nil and must have
 // at least one certificate.
 func DTLSServer(conn net.Conn, config *Config) *Conn {
+	if config.DTLSPacketLoss != nil {
+		conn = newDTLSAdversarialConn(conn, config.DTLSPacketLoss)
+	}
 	c := &Conn{config: config, isDTLS: true, conn: conn}
 	c.init()
 	return c
@@ -637,6 +1246,9 @@ func DTLSServer(conn net.Conn, config *Config) *Conn {
 // The config cannot be nil: users must set either ServerHostname or
 // InsecureSkipVerify in the config.
 func DTLSClient(conn net.Conn, config *Config) *Conn {
+	if config.DTLSPacketLoss != nil {
+		conn = newDTLSAdversarialConn(conn, config.DTLSPacketLoss)
+	}
 	c := &Conn{config: config, isClient: true, isDTLS: true, conn: conn}
 	c.init()
 	return c